@@ -33,14 +33,12 @@ SPDX-License-Identifier: BSD-2-clause
 package main
 
 import (
-	"flag"      // flag.Parse(), etc.  For command line flags.
-	"fmt"       // fmt.Printf()
-	"gpsd"      // local "vendored" copy
-	"math/rand" // for rand.Intn()
-	"os"        // for os.Stderr(), os.Exit()
-	"sort"      // for sort.Sort()
-	"sync"      // for sync.WaitGroup
-	"time"      // for time.Second
+	"flag" // flag.Parse(), etc.  For command line flags.
+	"fmt"  // fmt.Printf()
+	"gpsd" // local "vendored" copy
+	"os"   // for os.Stderr(), os.Exit()
+	"sort" // for sort.Sort()
+	"sync" // for sync.WaitGroup
 )
 
 var (
@@ -105,12 +103,7 @@ func main() {
 	wg.Add(1)
 	go func(gpsdHost string, gpsDataChan chan interface{}) {
 		defer wg.Done()
-		for {
-			gpsd.ConnGPSD(gpsdConn, gpsDataChan)
-			// lost connection, wait, then retry
-			time.Sleep(time.Duration(rand.Intn(5)+1) *
-				time.Second)
-		}
+		gpsd.ConnGPSDLoop(gpsdConn, gpsDataChan, gpsd.DefaultBackoff)
 	}(gpsdHost, gpsDataChan)
 
 	// Create go routine to receive the decoded messages.
@@ -126,8 +119,8 @@ func main() {
 
 				// Sort devices?
 				for _, device := range devices.Devices {
-					fmt.Printf("  DEVICE %s Driver %s " +
-                                                "Subtype %s %s\n",
+					fmt.Printf("  DEVICE %s Driver %s "+
+						"Subtype %s %s\n",
 						device.Path, device.Driver,
 						device.Subtype, device.Subtype1)
 				}
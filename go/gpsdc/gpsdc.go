@@ -12,15 +12,13 @@
 package main
 
 import (
-	"flag"      // flag.Parse(), etc.  For command line flags.
-	"fmt"       // fmt.Printf()
-	"gpsd"      // local "vendored" copy
-	"math/rand" // for rand.Intn()
-	"os"        // for os.Stderr(), os.Exit()
+	"flag" // flag.Parse(), etc.  For command line flags.
+	"fmt"  // fmt.Printf()
+	"gpsd" // local "vendored" copy
+	"os"   // for os.Stderr(), os.Exit()
 	// "reflect"  // for reflect.TypeOf()
 	"sort" // for sort.Sort()
 	"sync" // for sync.WaitGroup
-	"time" // for time.Second
 )
 
 var (
@@ -72,12 +70,7 @@ func main() {
 	wg.Add(1)
 	go func(gpsdHost string, gpsDataChan chan interface{}) {
 		defer wg.Done()
-		for {
-			gpsd.ConnGPSD(gpsdConn, gpsDataChan)
-			// lost connection, wait, then retry
-			time.Sleep(time.Duration(rand.Intn(5)+1) *
-				time.Second)
-		}
+		gpsd.ConnGPSDLoop(gpsdConn, gpsDataChan, gpsd.DefaultBackoff)
 	}(gpsdHost, gpsDataChan)
 
 	// create go routine for the output
@@ -0,0 +1,249 @@
+//
+// Package gdl90 encodes gpsd TPV/SKY reports as GDL90 frames, the
+// wire format used by ADS-B traffic displays and EFBs such as
+// ForeFlight and SkyDemon.
+//
+// See the GDL 90 Data Interface Specification (560-1058-00, Garmin)
+// for the message layouts implemented here.
+//
+// This file is Copyright 2022 by the GPSD project
+// SPDX-License-Identifier: BSD-2-clause
+//
+
+package gdl90
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gpsd" // local "vendored" copy
+)
+
+const (
+	flagByte   = 0x7E
+	escapeByte = 0x7D
+	escapeXor  = 0x20
+)
+
+// crc16Table is the CCITT (poly 0x1021) table GDL90's FCS uses.
+var crc16Table [256]uint16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if 0 != crc&0x8000 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc = crc << 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// fcs16() computes the GDL90 frame check sequence over msg.
+func fcs16(msg []byte) uint16 {
+	var crc uint16
+	for _, b := range msg {
+		crc = (crc << 8) ^ crc16Table[(crc>>8)^uint16(b)]
+	}
+	return crc
+}
+
+// frame() appends the FCS-16 to msg, byte-stuffs 0x7D/0x7E, and
+// wraps the result in 0x7E flag bytes.
+func frame(msg []byte) []byte {
+	crc := fcs16(msg)
+	raw := append(msg, byte(crc), byte(crc>>8))
+
+	out := make([]byte, 0, len(raw)+4)
+	out = append(out, flagByte)
+	for _, b := range raw {
+		if escapeByte == b || flagByte == b {
+			out = append(out, escapeByte, b^escapeXor)
+		} else {
+			out = append(out, b)
+		}
+	}
+	out = append(out, flagByte)
+	return out
+}
+
+// Encoder writes GDL90 frames to an underlying io.Writer, tagging
+// Ownship reports with the identity configured on it.
+type Encoder struct {
+	w io.Writer
+
+	// ICAO is this aircraft's 24-bit participant address, used by
+	// EmitOwnship() and EmitOwnshipGeoAlt().
+	ICAO uint32
+	// Callsign is this aircraft's callsign, used by EmitOwnship().
+	Callsign string
+	// EmitterCategory is the GDL90 emitter category, used by
+	// EmitOwnship().
+	EmitterCategory byte
+}
+
+// NewEncoder() returns an Encoder that writes frames to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) emit(msg []byte) error {
+	_, err := e.w.Write(frame(msg))
+	return err
+}
+
+// EmitHeartbeat() emits a Message ID 0 Heartbeat frame.  flags is
+// GDL90 Status Byte 1 (GPS-position-valid, maintenance-required,
+// etc); the UTC-timestamp-valid bit of Status Byte 2 is always set.
+func (e *Encoder) EmitHeartbeat(t time.Time, flags byte) error {
+	u := t.UTC()
+	secs := uint32(u.Hour())*3600 + uint32(u.Minute())*60 + uint32(u.Second())
+
+	msg := make([]byte, 7)
+	msg[0] = 0
+	msg[1] = flags
+	msg[2] = 0x01 | byte((secs>>16)&0x01)<<7 // bit7 = timestamp bit 16
+	msg[3] = byte(secs)
+	msg[4] = byte(secs >> 8)
+	msg[5] = 0
+	msg[6] = 0
+	return e.emit(msg)
+}
+
+// semicircles() converts signed decimal degrees into a 24-bit signed
+// GDL90 "semicircle" value.
+func semicircles(deg float64) int32 {
+	return int32(deg * (1 << 23) / 180.0)
+}
+
+// put24() appends the low 24 bits of v to msg, big-endian.
+func put24(msg []byte, v int32) {
+	msg[0] = byte(v >> 16)
+	msg[1] = byte(v >> 8)
+	msg[2] = byte(v)
+}
+
+// trafficReport() builds the common 28-byte body shared by the
+// Ownship Report (ID 10) and Traffic Report (ID 20) messages.
+func trafficReport(id byte, addrType byte, icao uint32, lat, lon,
+	altFt float64, nic, nacp byte, hVelKts, vVelFpm, trackDeg float64,
+	emitterCat byte, callsign string) []byte {
+
+	msg := make([]byte, 28)
+	msg[0] = id
+	msg[1] = addrType & 0x0F
+	put24(msg[2:5], int32(icao))
+	put24(msg[5:8], semicircles(lat))
+	put24(msg[8:11], semicircles(lon))
+
+	altCode := uint16(0xFFF) // 0xFFF == invalid/unavailable
+	if gpsd.IsFinite(altFt) {
+		v := int((altFt + 1000) / 25)
+		if 0 > v {
+			v = 0
+		} else if 0xFFE < v {
+			v = 0xFFE
+		}
+		altCode = uint16(v)
+	}
+	msg[11] = byte(altCode >> 4)
+	msg[12] = byte(altCode<<4) & 0xF0 // low nibble is the misc field, left 0
+
+	msg[13] = (nic << 4) | (nacp & 0x0F)
+
+	hVel := uint16(0xFFF) // unavailable
+	if gpsd.IsFinite(hVelKts) {
+		v := uint16(hVelKts)
+		if 0xFFE < v {
+			v = 0xFFE
+		}
+		hVel = v
+	}
+	vVel := int16(0x800) // unavailable, 12-bit signed sentinel
+	if gpsd.IsFinite(vVelFpm) {
+		v := int16(vVelFpm / 64)
+		if 2047 < v {
+			v = 2047
+		} else if -2048 > v {
+			v = -2048
+		}
+		vVel = v
+	}
+	msg[14] = byte(hVel >> 4)
+	msg[15] = byte(hVel<<4) | byte((uint16(vVel)>>8)&0x0F)
+	msg[16] = byte(vVel)
+
+	msg[17] = byte(int(trackDeg*256/360) & 0xFF)
+	msg[18] = emitterCat
+
+	cs := []byte(fmt.Sprintf("%-8s", callsign))
+	copy(msg[19:27], cs[:8])
+
+	return msg
+}
+
+// EmitOwnship() emits a Message ID 10 Ownship Report for tpv, using
+// the identity fields configured on the Encoder.  NACp comes from
+// tpv.NACp (see the gpsd package's AC 20-165A NACp derivation); NIC
+// is reported the same as NACp, which is what most EFB-facing GDL90
+// encoders do absent a real integrity figure from the receiver.
+func (e *Encoder) EmitOwnship(tpv *gpsd.TPV) error {
+	altFt := tpv.AltMSL * 3.28084
+	hVelKts := tpv.Speed * 1.943844
+	vVelFpm := tpv.Climb * 196.850394
+	nacp := byte(tpv.NACp)
+
+	msg := trafficReport(10, 0, e.ICAO, float64(tpv.Lat), float64(tpv.Lon),
+		altFt, nacp, nacp, hVelKts, vVelFpm, tpv.Track,
+		e.EmitterCategory, e.Callsign)
+	return e.emit(msg)
+}
+
+// EmitOwnshipGeoAlt() emits a Message ID 11 Ownship Geometric
+// Altitude for tpv.AltHAE.  No vertical figure-of-merit is available
+// from gpsd, so it is reported as not-available.
+func (e *Encoder) EmitOwnshipGeoAlt(tpv *gpsd.TPV) error {
+	msg := make([]byte, 5)
+	msg[0] = 11
+
+	enc := int16(0)
+	if gpsd.IsFinite(tpv.AltHAE) {
+		enc = int16((tpv.AltHAE * 3.28084) / 5)
+	}
+	msg[1] = byte(enc >> 8)
+	msg[2] = byte(enc)
+
+	vfom := uint16(0x7FFF) // not available
+	msg[3] = byte(vfom >> 8)
+	msg[4] = byte(vfom)
+	return e.emit(msg)
+}
+
+// Traffic describes one non-ownship aircraft for EmitTraffic().
+type Traffic struct {
+	ICAO            uint32
+	Callsign        string
+	AddrType        byte
+	Lat             float64 // degrees
+	Lon             float64 // degrees
+	AltFt           float64
+	TrackDeg        float64
+	GroundSpeedKts  float64
+	VerticalFpm     float64
+	EmitterCategory byte
+	NIC             byte
+	NACp            byte
+}
+
+// EmitTraffic() emits a Message ID 20 Traffic Report for a single
+// non-ownship aircraft.
+func (e *Encoder) EmitTraffic(t Traffic) error {
+	msg := trafficReport(20, t.AddrType, t.ICAO, t.Lat, t.Lon, t.AltFt,
+		t.NIC, t.NACp, t.GroundSpeedKts, t.VerticalFpm, t.TrackDeg,
+		t.EmitterCategory, t.Callsign)
+	return e.emit(msg)
+}
@@ -0,0 +1,137 @@
+//
+// GNSS constellation constants, and satellite sorting/naming helpers.
+//
+// This file is Copyright 2022 by the GPSD project
+// SPDX-License-Identifier: BSD-2-clause
+//
+
+package gpsd
+
+import "fmt"
+
+// Gnssid values, matching gpsd's own gnssid table (and u-blox's).
+const (
+	GNSS_GPS     = 0
+	GNSS_SBAS    = 1
+	GNSS_GALILEO = 2
+	GNSS_BEIDOU  = 3
+	GNSS_IMES    = 4
+	GNSS_QZSS    = 5
+	GNSS_GLONASS = 6
+	GNSS_NAVIC   = 7
+)
+
+// gnssNames gives the human name for each Gnssid, indexed by Gnssid.
+var gnssNames = []string{
+	GNSS_GPS:     "GPS",
+	GNSS_SBAS:    "SBAS",
+	GNSS_GALILEO: "Galileo",
+	GNSS_BEIDOU:  "BeiDou",
+	GNSS_IMES:    "IMES",
+	GNSS_QZSS:    "QZSS",
+	GNSS_GLONASS: "GLONASS",
+	GNSS_NAVIC:   "NavIC",
+}
+
+// sigNames gives the human name for each (Gnssid, Sigid) pair, as
+// used in the gpsd SKY report.  Unknown combinations fall back to a
+// generic "<constellation> sigid N" string.
+var sigNames = map[[2]int]string{
+	{GNSS_GPS, 0}:     "GPS L1C/A",
+	{GNSS_GPS, 1}:     "GPS L2 CL",
+	{GNSS_GPS, 2}:     "GPS L2 CM",
+	{GNSS_GPS, 3}:     "GPS L5 I",
+	{GNSS_GPS, 4}:     "GPS L5 Q",
+	{GNSS_SBAS, 0}:    "SBAS L1C/A",
+	{GNSS_GALILEO, 0}: "Galileo E1 C",
+	{GNSS_GALILEO, 1}: "Galileo E1 B",
+	{GNSS_GALILEO, 2}: "Galileo E5 bl",
+	{GNSS_GALILEO, 3}: "Galileo E5 bQ",
+	{GNSS_BEIDOU, 0}:  "BeiDou B1I D1",
+	{GNSS_BEIDOU, 1}:  "BeiDou B1I D2",
+	{GNSS_BEIDOU, 2}:  "BeiDou B2I D1",
+	{GNSS_BEIDOU, 3}:  "BeiDou B2I D2",
+	{GNSS_QZSS, 0}:    "QZSS L1C/A",
+	{GNSS_QZSS, 4}:    "QZSS L1S",
+	{GNSS_QZSS, 5}:    "QZSS L2 CM",
+	{GNSS_QZSS, 6}:    "QZSS L2 CL",
+	{GNSS_GLONASS, 0}: "GLONASS L1 OF",
+	{GNSS_GLONASS, 2}: "GLONASS L2 OF",
+}
+
+// SigName() returns the human name of a (gnssid, sigid) signal pair,
+// e.g. "GPS L1C/A" or "Galileo E5 bl".  Today's SATELLITE only
+// exposes Gnssid and Sigid as bare ints; this is the lookup everyone
+// downstream was reimplementing.
+func SigName(gnssid int, sigid int) string {
+	if name, ok := sigNames[[2]int{gnssid, sigid}]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s sigid %d", ConstellationName(gnssid), sigid)
+}
+
+// ConstellationName() returns the human name of a gnssid, e.g. "GPS"
+// or "BeiDou".  Unknown gnssid values return "unknown".
+func ConstellationName(gnssid int) string {
+	if 0 <= gnssid && len(gnssNames) > gnssid {
+		return gnssNames[gnssid]
+	}
+	return "unknown"
+}
+
+// Constellation() is a convenience wrapper around ConstellationName()
+// for a single satellite.
+func (s SATELLITE) Constellation() string {
+	return ConstellationName(s.Gnssid)
+}
+
+// NMEAToGNSS() classifies an NMEA 0183 PRN into a gnssid, using the
+// same PRN ranges gpsd's own NMEA driver uses.  This lets the NMEA
+// reader fill in Gnssid without a separate table of its own.
+func NMEAToGNSS(prn int) int {
+	switch {
+	case 1 <= prn && 32 >= prn:
+		return GNSS_GPS
+	case 33 <= prn && 64 >= prn:
+		return GNSS_SBAS
+	case 65 <= prn && 96 >= prn:
+		return GNSS_GLONASS
+	case 152 <= prn && 158 >= prn:
+		return GNSS_SBAS
+	case 173 <= prn && 182 >= prn:
+		return GNSS_IMES
+	case 193 <= prn && 197 >= prn:
+		return GNSS_QZSS
+	case 201 <= prn && 235 >= prn:
+		return GNSS_BEIDOU
+	case 301 <= prn && 336 >= prn:
+		return GNSS_GALILEO
+	default:
+		return GNSS_GPS
+	}
+}
+
+// ByGNSS sorts satellites by (Gnssid, Svid, Sigid), with satellites
+// used in the current fix sorted before those that are not.
+type ByGNSS []SATELLITE
+
+func (s ByGNSS) Len() int {
+	return len(s)
+}
+
+func (s ByGNSS) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s ByGNSS) Less(i, j int) bool {
+	if s[i].Used != s[j].Used {
+		return s[i].Used
+	}
+	if s[i].Gnssid != s[j].Gnssid {
+		return s[i].Gnssid < s[j].Gnssid
+	}
+	if s[i].Svid != s[j].Svid {
+		return s[i].Svid < s[j].Svid
+	}
+	return s[i].Sigid < s[j].Sigid
+}
@@ -10,9 +10,11 @@
 package gpsd
 
 import (
+	"bufio" // for bufio.Scanner
+
 	// Warning: Go json module is not fully compatible with Python JSON.
 	// Warning: Go json module does not fully implement the JSON spec.
-	"encoding/json" // for json.Unmarshall()
+	"encoding/json" // for json.Unmarshall(), json.Marshal()
 
 	"errors" // for errors.New()
 	"fmt"
@@ -23,11 +25,21 @@ import (
 	// Results are not consistent across implementations, by design.
 	// does not define constant NaN.
 	// does not define isFinite()
-	"math"    // for math.Log()
-	"net"     // for net.Dial(), net.Conn, etc.
-	"strings" // for strings.Split()
+	"math"      // for math.Log()
+	"math/rand" // for rand.Float64()
+	"net"       // for net.Dial(), net.Conn, etc.
+	"os"        // for os.Open(), os.OpenFile()
+	"strings"   // for strings.HasPrefix()
+	"time"      // for time.Second
+
+	"github.com/tarm/serial" // for serial.OpenPort()
 )
 
+// scannerBufSize is the maximum size of a single gpsd JSON message we
+// will accept.  SKY reports with many satellites can run well past the
+// default bufio.Scanner limit (64 KiB), so give ourselves headroom.
+const scannerBufSize = 128 * 1024
+
 // add a wrapper over loa.Logger module
 // logging levels
 type LogLvl int
@@ -113,6 +125,7 @@ func (d GFloat) String() string {
 
 type GPSData struct {
 	Class string
+	Time  string
 	// delay parsing until we know the class
 }
 
@@ -240,6 +253,8 @@ type TPV struct {
 	Magtrack    float64
 	Magvar      float64
 	Mode        int
+	NACp        int // derived FAA NACp, see NACpTable
+	NACv        int // derived FAA NACv
 	RelD        float64
 	RelE        float64
 	RelN        float64
@@ -262,6 +277,7 @@ type TPV struct {
 // Return a new TPV, with good defaults
 func NewTPV() *TPV {
 	return &TPV{AltHAE: NaN, AltMSL: NaN,
+		Eph: NaN, Epx: NaN, Epy: NaN, Eps: NaN,
 		Lat: GFloat(NaN), Lon: GFloat(NaN)}
 }
 
@@ -289,40 +305,202 @@ type WATCH struct {
 
 // describe a context/connection to a GPSD source
 type Context struct {
-	Conn     net.Conn
+	// Conn is any readable/writable source: a TCP or unix socket,
+	// a serial port, or a plain file being replayed.
+	Conn     io.ReadWriteCloser
 	Device   string
 	Filename string
 	Host     string // hostname or IP
 	Port     string // srouce port
-	Type     string // tcp, tcp4, tcp6, udp, udp4, udp6, file, unix (socket)
+	Type     string // tcp, tcp4, tcp6, udp, udp4, udp6, file, unix (socket), serial
 	// file, device
+	Path   string // device or file path, for "serial", "file" and "unix"
+	Baud   int    // serial speed.  0 means try 9600, 38400, then 115200
+	Format string // "gpsd" (default) JSON reports, or "nmea" for raw NMEA 0183
+	// Replay, when Type is "file", paces delivery of each message
+	// to match the gap between its "time" field and the previous
+	// one, so a capture plays back as if it were live.  Only
+	// honoured by Reader(), i.e. Format "gpsd".
+	Replay    bool
 	Verbosity int      // 0 = ERROR, 1 = WARN, 2 = INFO
 	GLog      *GLogger // GPSD logging
 }
 
+// sourceOpener opens the connection described by a Context and
+// returns it, without touching src.Conn itself.
+type sourceOpener func(*Context) (io.ReadWriteCloser, error)
+
+// sourceOpeners holds the openers for connection types that aren't
+// simply "tcp*" or "udp*", which Open() recognizes by prefix.
+var sourceOpeners = map[string]sourceOpener{
+	"unix":   openUnix,
+	"file":   openFile,
+	"serial": openSerial,
+}
+
 // Open a connection to a gpsd source.
 // Connection specified by SOURCE struct
-// Eventually will know about files, read-only, etc.
 func Open(src *Context) error {
 
-	var err error = nil
+	opener, ok := sourceOpeners[src.Type]
+	if !ok {
+		switch {
+		case strings.HasPrefix(src.Type, "tcp"):
+			opener = openTCP
+		case strings.HasPrefix(src.Type, "udp"):
+			opener = openUDP
+		default:
+			return errors.New(fmt.Sprintf(
+				"Unsupported connection type '%s'\n", src.Type))
+		}
+	}
 
-	switch src.Type {
-	case "tcp":
-		fallthrough
-	case "tcp4":
-		fallthrough
-	case "tcp6":
-		src.Conn, err = net.Dial(src.Type, src.Host+":"+src.Port)
+	conn, err := opener(src)
+	if nil != err {
+		return err
+	}
+	src.Conn = conn
+	return nil
+}
+
+func openTCP(src *Context) (io.ReadWriteCloser, error) {
+	conn, err := net.Dial(src.Type, src.Host+":"+src.Port)
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to connect to GPSD: %v", err))
+	}
+	return conn, nil
+}
+
+// udpConn adapts a connectionless net.PacketConn, fixed to one
+// remote address, to io.ReadWriteCloser.
+//
+// net.PacketConn.ReadFrom() silently truncates any datagram larger
+// than the buffer it is given, and bufio.Scanner (which Reader() and
+// ReaderNMEA() wrap every Conn in) does not always offer a Read() a
+// slice as large as scannerBufSize -- its free space shrinks as a
+// partial line accumulates.  So each datagram is read whole into a
+// buffer sized for the largest message this module accepts, and
+// drained into the caller's slice across however many Read() calls
+// that takes; Scan() never sees a truncated datagram.
+type udpConn struct {
+	pc      net.PacketConn
+	raddr   net.Addr
+	pending []byte
+}
+
+func (u *udpConn) Read(p []byte) (int, error) {
+	if 0 == len(u.pending) {
+		buf := make([]byte, scannerBufSize)
+		n, _, err := u.pc.ReadFrom(buf)
 		if nil != err {
-			err = errors.New(fmt.Sprintf(
-				"Failed to connect to GPSD: %v", err))
+			return 0, err
 		}
-	default:
-		err = errors.New(fmt.Sprintf(
-			"Unsupported connection type '%s'\n", src.Type))
+		u.pending = buf[:n]
 	}
-	return err
+
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	return n, nil
+}
+
+func (u *udpConn) Write(p []byte) (int, error) {
+	return u.pc.WriteTo(p, u.raddr)
+}
+
+func (u *udpConn) Close() error {
+	return u.pc.Close()
+}
+
+func openUDP(src *Context) (io.ReadWriteCloser, error) {
+	raddr, err := net.ResolveUDPAddr(src.Type, src.Host+":"+src.Port)
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to resolve GPSD address: %v", err))
+	}
+	pc, err := net.ListenPacket(src.Type, ":0")
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to connect to GPSD: %v", err))
+	}
+	return &udpConn{pc: pc, raddr: raddr}, nil
+}
+
+func openUnix(src *Context) (io.ReadWriteCloser, error) {
+	conn, err := net.Dial("unix", src.Path)
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to connect to GPSD: %v", err))
+	}
+	return conn, nil
+}
+
+// openFile opens src.Path for replay.  Pacing, when src.Replay is
+// set, happens in Reader() as messages are read, not here.
+func openFile(src *Context) (io.ReadWriteCloser, error) {
+	f, err := os.Open(src.Path)
+	if nil != err {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to open '%s': %v", src.Path, err))
+	}
+	return f, nil
+}
+
+// serialBauds are the rates tried, in order, when Context.Baud is 0.
+var serialBauds = []int{9600, 38400, 115200}
+
+// looksLikeText reports whether buf looks like printable ASCII, the
+// heuristic used to confirm auto-baud detection picked the right rate.
+func looksLikeText(buf []byte) bool {
+	if 0 == len(buf) {
+		return false
+	}
+	for _, b := range buf {
+		if ('\r' != b && '\n' != b) && (0x20 > b || 0x7e < b) {
+			return false
+		}
+	}
+	return true
+}
+
+// openSerial opens a serial device for src using src.Baud, or, if
+// src.Baud is 0, by trying each rate in serialBauds until one reads
+// back recognizable text.
+func openSerial(src *Context) (io.ReadWriteCloser, error) {
+
+	bauds := serialBauds
+	if 0 != src.Baud {
+		bauds = []int{src.Baud}
+	}
+
+	var err error
+	for _, baud := range bauds {
+		cfg := &serial.Config{
+			Name:        src.Path,
+			Baud:        baud,
+			ReadTimeout: time.Second,
+		}
+		var port *serial.Port
+		port, err = serial.OpenPort(cfg)
+		if nil != err {
+			continue
+		}
+
+		if 1 < len(bauds) {
+			probe := make([]byte, 64)
+			n, rerr := port.Read(probe)
+			if nil != rerr || !looksLikeText(probe[:n]) {
+				port.Close()
+				err = errors.New("no recognizable data at this baud rate")
+				continue
+			}
+		}
+
+		src.Baud = baud
+		return port, nil
+	}
+	return nil, errors.New(fmt.Sprintf(
+		"Failed to open serial device '%s': %v", src.Path, err))
 }
 
 /* Reader() reads messages from gpsd, parses them, and sends them as
@@ -332,101 +510,126 @@ func Open(src *Context) error {
  */
 func (src *Context) Reader(gpsDataChan chan interface{}) error {
 
-	buf := make([]byte, 4096)
+	// gpsd writes one JSON object per line, but a single TCP read
+	// can split a message anywhere, or bundle several together.
+	// bufio.Scanner reassembles reads into whole lines for us, so
+	// a large SKY report is never torn in half.
+	scanner := bufio.NewScanner(src.Conn)
+	scanner.Buffer(make([]byte, 4096), scannerBufSize)
 
-	// only leaves the loop on read errors
-	for {
-		// FIXME: Does not handle messages split across reads.
-		n, err := src.Conn.Read(buf)
-		if nil != err {
-			err = errors.New(fmt.Sprintf(
-				"Failed to read from GPSD: %v", err))
-			return err
+	watched := false
+	var lastReplay time.Time
+
+	// only leaves the loop on read errors or EOF
+	for scanner.Scan() {
+
+		line := scanner.Bytes()
+		if 0 == len(line) {
+			// skip empty lines
+			continue
 		}
 
-		// one read can contain many messages, we hope each is complete.
-		lines := strings.Split(string(buf[:n]), "\n")
+		// get a partial decode to find out the class
+		gpsdmsg := new(GPSData)
+		err := json.Unmarshal(line, &gpsdmsg)
+		if nil != err {
+			src.GLog.Log(LOG_WARN,
+				"Failed to unmarshal GPS data: %v\n",
+				err)
+			continue
+		}
+		// gpsDataChan <- *gpsdmsg
 
-		for _, line := range lines {
+		if src.Replay {
+			replayPace(&lastReplay, gpsdmsg.Time)
+		}
 
-			if 0 == len(line) {
-				// skip empty lines
+		switch gpsdmsg.Class {
+		case "DEVICES":
+			devices := new(DEVICES)
+			err = json.Unmarshal(line, &devices)
+			if nil != err {
+				src.GLog.Log(LOG_WARN,
+					"DEVICES: %v\n", err)
 				continue
 			}
-
-			// get a partial decode to find out the class
-			gpsdmsg := new(GPSData)
-			err = json.Unmarshal([]byte(line), &gpsdmsg)
+			src.GLog.Log(LOG_PROG,
+				"DEVICES %+v\n", devices)
+		case "PPS":
+			pps := new(PPS)
+			err = json.Unmarshal(line, &pps)
 			if nil != err {
 				src.GLog.Log(LOG_WARN,
-					"Failed to unmarshal GPS data: %v\n",
-					err)
+					"PPS: %v\n", err)
 				continue
 			}
-			// gpsDataChan <- *gpsdmsg
-
-			switch gpsdmsg.Class {
-			case "DEVICES":
-				devices := new(DEVICES)
-				err = json.Unmarshal([]byte(line), &devices)
-				if nil != err {
-					src.GLog.Log(LOG_WARN,
-						"DEVICES: %v\n", err)
-					continue
-				}
-				src.GLog.Log(LOG_PROG,
-					"DEVICES %+v\n", devices)
-			case "PPS":
-				pps := new(PPS)
-				err = json.Unmarshal([]byte(line), &pps)
-				if nil != err {
-					src.GLog.Log(LOG_WARN,
-						"PPS: %v\n", err)
-					continue
-				}
-				src.GLog.Log(LOG_PROG, "PPS %+v\n", pps)
-			case "SKY":
-				sky := NewSKY()
-				err = json.Unmarshal([]byte(line), &sky)
-				if nil != err {
-					src.GLog.Log(LOG_WARN,
-						"SKY: %v\n", err)
-					continue
-				}
-				// FIXME: unpack SATELLITES
-				src.GLog.Log(LOG_PROG,
-					"SKY %+v\n", sky)
-			case "TPV":
-				tpv := NewTPV()
-				err = json.Unmarshal([]byte(line), &tpv)
+			src.GLog.Log(LOG_PROG, "PPS %+v\n", pps)
+		case "SKY":
+			sky := NewSKY()
+			err = json.Unmarshal(line, &sky)
+			if nil != err {
+				src.GLog.Log(LOG_WARN,
+					"SKY: %v\n", err)
+				continue
+			}
+			// FIXME: unpack SATELLITES
+			src.GLog.Log(LOG_PROG,
+				"SKY %+v\n", sky)
+			gpsDataChan <- sky
+		case "TPV":
+			tpv := NewTPV()
+			err = json.Unmarshal(line, &tpv)
+			if nil != err {
+				src.GLog.Log(LOG_WARN,
+					"TPV: %v\n", err)
+				continue
+			}
+			tpv.applyNACp()
+			src.GLog.Log(LOG_PROG, "TPV %+v\n", tpv)
+			gpsDataChan <- tpv
+		case "VERSION":
+			version := new(VERSION)
+			err = json.Unmarshal(line, &version)
+			if nil != err {
+				src.GLog.Log(LOG_WARN, "VERSION: %v\n", err)
+				continue
+			}
+			src.GLog.Log(LOG_PROG, "VERSION %+v\n", version)
+			if !watched && "file" != src.Type {
+				// Nothing enables the JSON stream until we
+				// ask for it.  Do that as soon as we know
+				// gpsd is alive, rather than making every
+				// caller remember to.  A "file" source is a
+				// replay, not a live gpsd, and is read-only.
+				err = src.Watch(WATCH{Enable: true, Json: true})
 				if nil != err {
 					src.GLog.Log(LOG_WARN,
-						"TPV: %v\n", err)
-					continue
-				}
-				src.GLog.Log(LOG_PROG, "TPV %+v\n", tpv)
-				gpsDataChan <- tpv
-			case "VERSION":
-				version := new(VERSION)
-				err = json.Unmarshal([]byte(line), &version)
-				if nil != err {
-					src.GLog.Log(LOG_WARN, "VERSION: %v\n", err)
+						"WATCH request failed: %v\n", err)
 					continue
 				}
-				src.GLog.Log(LOG_PROG, "VERSION %+v\n", version)
-			case "WATCH":
-				watch := new(WATCH)
-				err = json.Unmarshal([]byte(line), &watch)
-				if nil != err {
-					src.GLog.Log(LOG_WARN, "WATCH error: %v\n", err)
-					continue
-				}
-				src.GLog.Log(LOG_PROG, "WATCH %+v\n", watch)
-			default:
-				fmt.Printf("Unknown class '%s'\n", gpsdmsg.Class)
+				watched = true
+			}
+		case "WATCH":
+			watch := new(WATCH)
+			err = json.Unmarshal(line, &watch)
+			if nil != err {
+				src.GLog.Log(LOG_WARN, "WATCH error: %v\n", err)
+				continue
 			}
+			src.GLog.Log(LOG_PROG, "WATCH %+v\n", watch)
+		default:
+			fmt.Printf("Unknown class '%s'\n", gpsdmsg.Class)
 		}
 	}
+
+	err := scanner.Err()
+	if nil == err {
+		err = errors.New("Connection to GPSD closed")
+	} else {
+		err = errors.New(fmt.Sprintf(
+			"Failed to read from GPSD: %v", err))
+	}
+	return err
 }
 
 // Write to a gpsd connection.
@@ -442,3 +645,136 @@ func (src *Context) Writer(wstr []byte) error {
 	}
 	return err
 }
+
+// Watch() sends a ?WATCH= request, enabling or adjusting the
+// subscription to the JSON report stream.
+func (src *Context) Watch(w WATCH) error {
+	data, err := json.Marshal(w)
+	if nil != err {
+		return errors.New(fmt.Sprintf(
+			"Failed to marshal WATCH: %v", err))
+	}
+	return src.Writer(append([]byte("?WATCH="), data...))
+}
+
+// Poll() sends a ?POLL; request, asking gpsd for a one-time snapshot
+// of current data.
+func (src *Context) Poll() error {
+	return src.Writer([]byte("?POLL;"))
+}
+
+// DeviceCmd() sends a ?DEVICE= request, querying or changing the
+// configuration of a device.
+func (src *Context) DeviceCmd(d DEVICE) error {
+	data, err := json.Marshal(d)
+	if nil != err {
+		return errors.New(fmt.Sprintf(
+			"Failed to marshal DEVICE: %v", err))
+	}
+	return src.Writer(append([]byte("?DEVICE="), data...))
+}
+
+// Version() sends a ?VERSION; request, asking gpsd to identify itself.
+func (src *Context) Version() error {
+	return src.Writer([]byte("?VERSION;"))
+}
+
+/* ConnGPSD() opens a connection to a gpsd source and reads from it
+ * until the connection breaks.  Decoded messages are sent out
+ * gpsDataChan.  Callers typically call this in a loop, reconnecting
+ * after it returns.
+ */
+func ConnGPSD(src *Context, gpsDataChan chan interface{}) error {
+
+	err := Open(src)
+	if nil != err {
+		src.GLog.Log(LOG_ERROR, "%v\n", err)
+		return err
+	}
+	defer src.Conn.Close()
+
+	if "nmea" == src.Format {
+		return src.ReaderNMEA(gpsDataChan)
+	}
+
+	if "file" != src.Type {
+		// A "file" source is a replay of a capture, not a live
+		// gpsd: it is read-only, so there is nothing to ask it
+		// to identify itself.
+		err = src.Version()
+		if nil != err {
+			src.GLog.Log(LOG_ERROR, "%v\n", err)
+			return err
+		}
+	}
+
+	return src.Reader(gpsDataChan)
+}
+
+// replayPace sleeps as long as the gap between tstr and the
+// timestamp in *last, then updates *last to tstr.  Used by Reader()
+// to pace file replay.  The first call of a replay never sleeps.
+func replayPace(last *time.Time, tstr string) {
+	if "" == tstr {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, tstr)
+	if nil != err {
+		return
+	}
+	if !last.IsZero() {
+		if delta := t.Sub(*last); 0 < delta {
+			time.Sleep(delta)
+		}
+	}
+	*last = t
+}
+
+// BackoffPolicy describes a jittered exponential backoff, used by
+// ConnGPSDLoop() between reconnect attempts.
+type BackoffPolicy struct {
+	Base   time.Duration // delay before the first retry
+	Factor float64       // multiplier applied per attempt
+	Cap    time.Duration // maximum delay, before jitter
+	Jitter float64       // +/- fraction of the delay to randomize, e.g. 0.2
+}
+
+// DefaultBackoff is a reasonable backoff for reconnecting to a gpsd
+// that may be restarting: 1s, 2s, 4s, ... capped at 30s, +/-20%.
+var DefaultBackoff = BackoffPolicy{
+	Base:   time.Second,
+	Factor: 2,
+	Cap:    30 * time.Second,
+	Jitter: 0.2,
+}
+
+// Duration() returns the delay to use before reconnect attempt n
+// (0-based).
+func (b BackoffPolicy) Duration(attempt int) time.Duration {
+	d := float64(b.Base) * math.Pow(b.Factor, float64(attempt))
+	if capD := float64(b.Cap); d > capD {
+		d = capD
+	}
+	d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	if 0 > d {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+/* ConnGPSDLoop() calls ConnGPSD() in a loop, reconnecting with a
+ * jittered exponential backoff between attempts.  It only returns if
+ * gpsDataChan panics on send; otherwise it runs until the process
+ * exits, same as the hand-rolled retry loops it replaces.
+ */
+func ConnGPSDLoop(src *Context, gpsDataChan chan interface{}, backoff BackoffPolicy) {
+	attempt := 0
+	for {
+		err := ConnGPSD(src, gpsDataChan)
+		if nil != err {
+			src.GLog.Log(LOG_WARN, "%v\n", err)
+		}
+		time.Sleep(backoff.Duration(attempt))
+		attempt++
+	}
+}
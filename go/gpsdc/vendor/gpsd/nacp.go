@@ -0,0 +1,78 @@
+//
+// FAA NACp/NACv (navigation accuracy category) derivation, per AC
+// 20-165A, from the horizontal/vertical error estimates gpsd reports.
+//
+// This file is Copyright 2022 by the GPSD project
+// SPDX-License-Identifier: BSD-2-clause
+//
+
+package gpsd
+
+import "math"
+
+// NACpTable holds the horizontal 95% error thresholds, in meters,
+// for NACp 11 down to NACp 1, in that order.  Exposed so callers
+// with a non-aviation accuracy profile can override it.
+var NACpTable = []float64{
+	3,     // NACp 11
+	10,    // NACp 10
+	30,    // NACp 9
+	92.6,  // NACp 8, 0.05 NM
+	185.2, // NACp 7, 0.1 NM
+	555.6, // NACp 6, 0.3 NM
+	926,   // NACp 5, 0.5 NM
+	1852,  // NACp 4, 1 NM
+	3704,  // NACp 3, 2 NM
+	7408,  // NACp 2, 4 NM
+	18520, // NACp 1, 10 NM
+}
+
+// NACvTable holds the vertical speed error thresholds, in m/s, for
+// NACv 1 through NACv 4, in that order.
+var NACvTable = []float64{
+	10,  // NACv 1
+	3,   // NACv 2
+	1,   // NACv 3
+	0.3, // NACv 4
+}
+
+// nacpFromEph() buckets a 95% horizontal error, in meters, into an
+// FAA NACp value using NACpTable.  NaN or out-of-range Eph yields 0.
+func nacpFromEph(eph float64) int {
+	if !IsFinite(eph) {
+		return 0
+	}
+	for i, threshold := range NACpTable {
+		if eph < threshold {
+			return len(NACpTable) - i
+		}
+	}
+	return 0
+}
+
+// nacvFromEps() buckets a vertical speed error, in m/s, into an FAA
+// NACv value using NACvTable.  NaN or out-of-range Eps yields 0.
+func nacvFromEps(eps float64) int {
+	if !IsFinite(eps) {
+		return 0
+	}
+	for i := len(NACvTable) - 1; 0 <= i; i-- {
+		if eps <= NACvTable[i] {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// applyNACp() fills in tpv.NACp and tpv.NACv from the error
+// estimates gpsd already reported.  When Eph is missing but Epx/Epy
+// are present, Eph is derived as 2*sqrt(Epx^2+Epy^2), converting the
+// 2-sigma Epx/Epy into a 95% (roughly 2-sigma) horizontal estimate.
+func (tpv *TPV) applyNACp() {
+	eph := tpv.Eph
+	if !IsFinite(eph) && IsFinite(tpv.Epx) && IsFinite(tpv.Epy) {
+		eph = 2 * math.Sqrt(tpv.Epx*tpv.Epx+tpv.Epy*tpv.Epy)
+	}
+	tpv.NACp = nacpFromEph(eph)
+	tpv.NACv = nacvFromEps(tpv.Eps)
+}
@@ -0,0 +1,307 @@
+//
+// Native NMEA 0183 parsing, for reading directly from a serial or TCP
+// GPS that does not run gpsd.
+//
+// This file is Copyright 2022 by the GPSD project
+// SPDX-License-Identifier: BSD-2-clause
+//
+
+package gpsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gnssidForSat() classifies a GSV satellite's gnssid, preferring the
+// sentence's talker ID over the PRN number.  Talker ID is the more
+// reliable signal: some receivers emit $GLGSV with raw GLONASS slot
+// numbers 1-24 rather than the gpsd/u-blox 65-96 PRN convention, which
+// NMEAToGNSS() alone would misclassify as GPS.  "GN" (multi-GNSS) and
+// unrecognized talkers fall back to classifying by PRN.
+func gnssidForSat(talker string, prn int) int {
+	switch talker {
+	case "GP":
+		return GNSS_GPS
+	case "GL":
+		return GNSS_GLONASS
+	case "GA":
+		return GNSS_GALILEO
+	case "GB", "BD":
+		return GNSS_BEIDOU
+	case "GQ":
+		return GNSS_QZSS
+	default:
+		return NMEAToGNSS(prn)
+	}
+}
+
+// nmeaChecksumValid() checks the trailing "*HH" XOR checksum of a
+// single NMEA sentence, including the leading '$'.
+func nmeaChecksumValid(sentence string) bool {
+	star := strings.LastIndex(sentence, "*")
+	if 0 > star || star+3 > len(sentence) {
+		return false
+	}
+
+	var cksum byte
+	for i := 1; i < star; i++ {
+		cksum ^= sentence[i]
+	}
+
+	given, err := strconv.ParseUint(sentence[star+1:star+3], 16, 8)
+	if nil != err {
+		return false
+	}
+	return byte(given) == cksum
+}
+
+// nmeaLatLon() converts an NMEA ddmm.mmmm/dddmm.mmmm position field,
+// plus its N/S or E/W hemisphere letter, into signed decimal degrees.
+func nmeaLatLon(val string, hemi string) (GFloat, error) {
+	if 0 == len(val) {
+		return GFloat(NaN), errors.New("empty field")
+	}
+
+	dot := strings.Index(val, ".")
+	if 0 > dot || dot < 3 {
+		return GFloat(NaN), errors.New("malformed position")
+	}
+
+	deg, err := strconv.ParseFloat(val[:dot-2], 64)
+	if nil != err {
+		return GFloat(NaN), err
+	}
+	min, err := strconv.ParseFloat(val[dot-2:], 64)
+	if nil != err {
+		return GFloat(NaN), err
+	}
+
+	degrees := deg + min/60.0
+	if "S" == hemi || "W" == hemi {
+		degrees = -degrees
+	}
+	return GFloat(degrees), nil
+}
+
+// nmeaTime() combines an NMEA hhmmss.ss time-of-day field with an
+// optional ddmmyy date field into a gpsd-style ISO8601 timestamp.
+func nmeaTime(hhmmss string, ddmmyy string) string {
+	if 6 > len(hhmmss) {
+		return ""
+	}
+	if 6 != len(ddmmyy) {
+		// no date yet, gpsd has no good way to express time-only
+		return ""
+	}
+
+	layout := "020106150405"
+	t, err := time.Parse(layout, ddmmyy+hhmmss[:6])
+	if nil != err {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// nmeaGSVAccum tracks in-progress GSV sentences, which arrive as a
+// group of 2-4 sentences that must be merged into one SKY report.
+type nmeaGSVAccum struct {
+	talker string
+	total  int
+	seen   int
+	sats   []SATELLITE
+}
+
+// add() folds one GSV sentence into the accumulator.  It returns
+// true when the last sentence of the group has been consumed and
+// sats is ready to ship.
+func (g *nmeaGSVAccum) add(talker string, fields []string) bool {
+	total, _ := strconv.Atoi(fields[1])
+	num, _ := strconv.Atoi(fields[2])
+
+	if g.talker != talker || num <= g.seen {
+		// a new group started, possibly from a different
+		// constellation's talker ID; start over.
+		g.talker = talker
+		g.total = total
+		g.seen = 0
+		g.sats = nil
+	}
+	g.seen++
+
+	// up to 4 satellites packed per GSV sentence, fields[3:]
+	for i := 3; i+3 < len(fields); i += 4 {
+		prn, err := strconv.Atoi(fields[i])
+		if nil != err || 0 == prn {
+			continue
+		}
+		sat := NewSATELLITE()
+		sat.PRN = prn
+		sat.Svid = prn
+		if el, err := strconv.Atoi(fields[i+1]); nil == err {
+			sat.El = GFloat(el)
+		}
+		if az, err := strconv.Atoi(fields[i+2]); nil == err {
+			sat.Az = GFloat(az)
+		}
+		if ss, err := strconv.Atoi(fields[i+3]); nil == err {
+			sat.Ss = GFloat(ss)
+		}
+		sat.Gnssid = gnssidForSat(talker, prn)
+		g.sats = append(g.sats, *sat)
+	}
+
+	return g.seen >= g.total
+}
+
+/* ReaderNMEA() reads NMEA 0183 sentences from the source, parses
+ * them, and sends TPV/SKY structs out gpsDataChan, mirroring what
+ * Reader() does for gpsd JSON.
+ * Returns only when the connection is broken, EOF, etc.
+ */
+func (src *Context) ReaderNMEA(gpsDataChan chan interface{}) error {
+
+	scanner := bufio.NewScanner(src.Conn)
+	scanner.Buffer(make([]byte, 4096), scannerBufSize)
+
+	tpv := NewTPV()
+	sky := NewSKY()
+	gsv := new(nmeaGSVAccum)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if 0 == len(line) || '$' != line[0] {
+			continue
+		}
+		if !nmeaChecksumValid(line) {
+			src.GLog.Log(LOG_WARN, "NMEA: bad checksum: %s\n", line)
+			continue
+		}
+
+		star := strings.LastIndex(line, "*")
+		fields := strings.Split(line[1:star], ",")
+		sentence := fields[0]
+
+		var talker, stype string
+		if strings.HasPrefix(sentence, "P") {
+			// proprietary sentence, no 2-letter talker ID
+			stype = sentence
+		} else if 5 <= len(sentence) {
+			talker = sentence[:2]
+			stype = sentence[2:]
+		} else {
+			continue
+		}
+
+		switch stype {
+		case "RMC":
+			if 10 > len(fields) {
+				continue
+			}
+			tpv.Class = "TPV"
+			if lat, err := nmeaLatLon(fields[3], fields[4]); nil == err {
+				tpv.Lat = lat
+			}
+			if lon, err := nmeaLatLon(fields[5], fields[6]); nil == err {
+				tpv.Lon = lon
+			}
+			if speed, err := strconv.ParseFloat(fields[7], 64); nil == err {
+				tpv.Speed = speed * 0.514444 // knots to m/s
+			}
+			if track, err := strconv.ParseFloat(fields[8], 64); nil == err {
+				tpv.Track = track
+			}
+			tpv.Time = nmeaTime(fields[1], fields[9])
+			if "A" == fields[2] {
+				tpv.Status = 1
+			} else {
+				tpv.Status = 0
+			}
+			tpv.applyNACp()
+			gpsDataChan <- tpv
+			tpv = NewTPV()
+		case "GGA":
+			if 10 > len(fields) {
+				continue
+			}
+			if lat, err := nmeaLatLon(fields[2], fields[3]); nil == err {
+				tpv.Lat = lat
+			}
+			if lon, err := nmeaLatLon(fields[4], fields[5]); nil == err {
+				tpv.Lon = lon
+			}
+			if alt, err := strconv.ParseFloat(fields[9], 64); nil == err {
+				tpv.AltMSL = alt
+			}
+			if qual, err := strconv.Atoi(fields[6]); nil == err {
+				sky.Qual = GUint(qual)
+			}
+		case "GSA":
+			if 17 > len(fields) {
+				continue
+			}
+			if mode, err := strconv.Atoi(fields[2]); nil == err {
+				tpv.Mode = mode
+			}
+			if pdop, err := strconv.ParseFloat(fields[15], 64); nil == err {
+				sky.Pdop = pdop
+			}
+			if hdop, err := strconv.ParseFloat(fields[16], 64); nil == err {
+				sky.Hdop = hdop
+			}
+			if 17 < len(fields) {
+				if vdop, err := strconv.ParseFloat(fields[17], 64); nil == err {
+					sky.Vdop = vdop
+				}
+			}
+		case "VTG":
+			if 8 > len(fields) {
+				continue
+			}
+			if track, err := strconv.ParseFloat(fields[1], 64); nil == err {
+				tpv.Track = track
+			}
+			if speed, err := strconv.ParseFloat(fields[7], 64); nil == err {
+				tpv.Speed = speed / 3.6 // km/h to m/s
+			}
+		case "GSV":
+			if 4 > len(fields) {
+				continue
+			}
+			if gsv.add(talker, fields) {
+				sky.Class = "SKY"
+				sky.Satellites = gsv.sats
+				sky.NSat = GUint(len(gsv.sats))
+				gpsDataChan <- sky
+				sky = NewSKY()
+				gsv = new(nmeaGSVAccum)
+			}
+		case "PGRMZ":
+			// $PGRMZ,altitude,units,fix*CS
+			if 3 > len(fields) {
+				continue
+			}
+			if alt, err := strconv.ParseFloat(fields[1], 64); nil == err {
+				if "f" == fields[2] {
+					tpv.AltMSL = alt * 0.3048 // feet to meters
+				} else {
+					tpv.AltMSL = alt
+				}
+			}
+		default:
+			// ignore unsupported sentence types
+		}
+	}
+
+	err := scanner.Err()
+	if nil == err {
+		err = errors.New("Connection closed")
+	} else {
+		err = errors.New(fmt.Sprintf("Failed to read NMEA: %v", err))
+	}
+	return err
+}